@@ -3,6 +3,7 @@ package cli
 import (
 	"context"
 	"fmt"
+	"os"
 	"sort"
 	"time"
 
@@ -25,6 +26,25 @@ type blockIndexEntryInfo struct {
 	inline  bool
 }
 
+// blockIndexItemOutput is the structured (--output=json/yaml) representation
+// of a single entry within an index block.
+type blockIndexItemOutput struct {
+	BlockID string `json:"blockID" yaml:"blockID"`
+	Offset  uint32 `json:"offset" yaml:"offset"`
+	Size    uint32 `json:"size" yaml:"size"`
+	Inline  bool   `json:"inline" yaml:"inline"`
+}
+
+// blockIndexBlockOutput is the structured (--output=json/yaml) representation
+// of a single index block.
+type blockIndexBlockOutput struct {
+	PackBlockID  string                 `json:"packBlockId" yaml:"packBlockId"`
+	PackLength   uint32                 `json:"packLength" yaml:"packLength"`
+	CreatedAt    time.Time              `json:"createdAt" yaml:"createdAt"`
+	Items        []blockIndexItemOutput `json:"items" yaml:"items"`
+	DeletedItems []string               `json:"deletedItems" yaml:"deletedItems"`
+}
+
 func runShowBlockIndexesAction(ctx context.Context, rep *repo.Repository) error {
 	var blockIDs []block.PhysicalBlockID
 	for _, id := range *blockIndexShowIDs {
@@ -47,6 +67,8 @@ func runShowBlockIndexesAction(ctx context.Context, rep *repo.Repository) error
 		}
 	}
 
+	structured := []blockIndexBlockOutput{}
+
 	for _, blockID := range blockIDs {
 		data, err := rep.Blocks.GetIndexBlock(ctx, blockID)
 		if err != nil {
@@ -59,24 +81,51 @@ func runShowBlockIndexesAction(ctx context.Context, rep *repo.Repository) error
 		}
 
 		for _, ndx := range d.IndexesV1 {
+			if *outputFormatFlag != outputFormatText {
+				structured = append(structured, structuredIndexV1(ndx))
+				continue
+			}
+
 			printIndexV1(ndx)
 		}
 	}
 
+	if *outputFormatFlag != outputFormatText {
+		if _, err := maybeWriteStructuredOutput(os.Stdout, structured); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
-func printIndexV1(ndx *blockmgrpb.IndexV1) {
-	fmt.Printf("pack:%v len:%v created:%v\n", ndx.PackBlockId, ndx.PackLength, time.Unix(0, int64(ndx.CreateTimeNanos)).Local())
-	var lines []blockIndexEntryInfo
+func structuredIndexV1(ndx *blockmgrpb.IndexV1) blockIndexBlockOutput {
+	lines := indexV1Lines(ndx)
 
-	for blk, os := range ndx.Items {
-		lines = append(lines, blockIndexEntryInfo{blk, uint32(os >> 32), uint32(os), false})
+	out := blockIndexBlockOutput{
+		PackBlockID:  ndx.PackBlockId,
+		PackLength:   ndx.PackLength,
+		CreatedAt:    time.Unix(0, int64(ndx.CreateTimeNanos)).Local(),
+		Items:        []blockIndexItemOutput{},
+		DeletedItems: append([]string{}, ndx.DeletedItems...),
 	}
-	for blk, d := range ndx.InlineItems {
-		lines = append(lines, blockIndexEntryInfo{blk, 0, uint32(len(d)), true})
+
+	for _, l := range lines {
+		out.Items = append(out.Items, blockIndexItemOutput{
+			BlockID: l.blockID,
+			Offset:  l.offset,
+			Size:    l.size,
+			Inline:  l.inline,
+		})
 	}
-	sortIndexBlocks(lines)
+
+	return out
+}
+
+func printIndexV1(ndx *blockmgrpb.IndexV1) {
+	fmt.Printf("pack:%v len:%v created:%v\n", ndx.PackBlockId, ndx.PackLength, time.Unix(0, int64(ndx.CreateTimeNanos)).Local())
+
+	lines := indexV1Lines(ndx)
 	for _, l := range lines {
 		if l.inline {
 			fmt.Printf("  added %-40v size:%v (inline)\n", l.blockID, l.size)
@@ -89,6 +138,20 @@ func printIndexV1(ndx *blockmgrpb.IndexV1) {
 	}
 
 }
+
+func indexV1Lines(ndx *blockmgrpb.IndexV1) []blockIndexEntryInfo {
+	var lines []blockIndexEntryInfo
+
+	for blk, os := range ndx.Items {
+		lines = append(lines, blockIndexEntryInfo{blk, uint32(os >> 32), uint32(os), false})
+	}
+	for blk, d := range ndx.InlineItems {
+		lines = append(lines, blockIndexEntryInfo{blk, 0, uint32(len(d)), true})
+	}
+	sortIndexBlocks(lines)
+
+	return lines
+}
 func sortIndexBlocks(lines []blockIndexEntryInfo) {
 	switch *blockIndexShowSort {
 	case "offset":
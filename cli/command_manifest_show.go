@@ -3,8 +3,10 @@ package cli
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/kopia/kopia/repo"
 )
@@ -18,7 +20,19 @@ func init() {
 	manifestShowCommand.Action(repositoryAction(showManifestItems))
 }
 
+// manifestShowEntry is the structured (--output=json/yaml) representation of
+// a single manifest item.
+type manifestShowEntry struct {
+	ID      string            `json:"id" yaml:"id"`
+	Length  int               `json:"length" yaml:"length"`
+	ModTime time.Time         `json:"modTime" yaml:"modTime"`
+	Labels  map[string]string `json:"labels" yaml:"labels"`
+	Payload json.RawMessage   `json:"payload" yaml:"payload"`
+}
+
 func showManifestItems(ctx context.Context, rep *repo.Repository) error {
+	structured := []manifestShowEntry{}
+
 	for _, it := range *manifestShowItems {
 		md, err := rep.Manifests.GetMetadata(it)
 		if err != nil {
@@ -30,6 +44,17 @@ func showManifestItems(ctx context.Context, rep *repo.Repository) error {
 			return fmt.Errorf("error showing %q: %v", it, err)
 		}
 
+		if *outputFormatFlag != outputFormatText {
+			structured = append(structured, manifestShowEntry{
+				ID:      it,
+				Length:  md.Length,
+				ModTime: md.ModTime,
+				Labels:  md.Labels,
+				Payload: json.RawMessage(b),
+			})
+			continue
+		}
+
 		fmt.Fprintf(os.Stderr, "// id: %v\n", it)
 		fmt.Fprintf(os.Stderr, "// length: %v\n", md.Length)
 		fmt.Fprintf(os.Stderr, "// modified: %v\n", md.ModTime.Local().Format(timeFormat))
@@ -41,5 +66,11 @@ func showManifestItems(ctx context.Context, rep *repo.Repository) error {
 		}
 	}
 
+	if *outputFormatFlag != outputFormatText {
+		if _, err := maybeWriteStructuredOutput(os.Stdout, structured); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
\ No newline at end of file
@@ -0,0 +1,35 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/kopia/kopia/repo"
+)
+
+var (
+	manifestVerifyCommand = manifestCommands.Command("verify", "Verify that all manifest blocks can be loaded")
+)
+
+func init() {
+	manifestVerifyCommand.Action(repositoryAction(runManifestVerifyAction))
+}
+
+func runManifestVerifyAction(ctx context.Context, rep *repo.Repository) error {
+	report := rep.Manifests.LastLoadReport()
+	if report.IsEmpty() {
+		fmt.Fprintf(os.Stderr, "all manifest blocks loaded successfully.\n")
+		return nil
+	}
+
+	for _, e := range report.Errors {
+		fmt.Fprintf(os.Stderr, "// block: %v\n", e.BlockID)
+		fmt.Fprintf(os.Stderr, "// size: %v\n", e.Size)
+		fmt.Fprintf(os.Stderr, "// gzip error: %v\n", e.GzipError)
+		fmt.Fprintf(os.Stderr, "// json error: %v\n", e.JSONError)
+		fmt.Fprintf(os.Stderr, "// error: %v\n", e.Err)
+	}
+
+	return fmt.Errorf("found %v bad manifest block(s)", len(report.Errors))
+}
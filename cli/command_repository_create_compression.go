@@ -0,0 +1,23 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/kopia/kopia/manifest"
+)
+
+// manifestCompressionFlag lets `kopia repository create` pick the codec used
+// to compress newly-written manifest blocks.
+var manifestCompressionFlag = repositoryCreateCommand.Flag("manifest-compression", "Compression algorithm for manifest blocks").Default("gzip").Enum("none", "gzip", "zstd")
+
+// manifestLoadOptionsFromFlags builds the manifest.LoadOptions to pass to
+// manifest.NewManager when creating a new repository, honoring
+// --manifest-compression.
+func manifestLoadOptionsFromFlags() (manifest.LoadOptions, error) {
+	codec, err := manifest.CodecByName(*manifestCompressionFlag)
+	if err != nil {
+		return manifest.LoadOptions{}, fmt.Errorf("invalid --manifest-compression: %v", err)
+	}
+
+	return manifest.LoadOptions{Codec: codec}, nil
+}
@@ -0,0 +1,23 @@
+package cli
+
+import (
+	"net/http"
+
+	"github.com/kopia/kopia/manifest"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// registerManifestMetricsHandler registers mgr's Prometheus metrics with a
+// dedicated registry and mounts them at "/metrics" on mux. Called by the
+// `kopia server` command during startup.
+func registerManifestMetricsHandler(mux *http.ServeMux, mgr *manifest.Manager) error {
+	reg := prometheus.NewRegistry()
+	if err := mgr.RegisterMetrics(reg); err != nil {
+		return err
+	}
+
+	mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+
+	return nil
+}
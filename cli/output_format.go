@@ -0,0 +1,40 @@
+package cli
+
+import (
+	"encoding/json"
+	"io"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	outputFormatText = "text"
+	outputFormatJSON = "json"
+	outputFormatYAML = "yaml"
+)
+
+var outputFormatFlag = app.Flag("output", "Output format").Default(outputFormatText).Enum(outputFormatText, outputFormatJSON, outputFormatYAML)
+
+// maybeWriteStructuredOutput writes v as JSON or YAML to w according to the
+// global --output flag and reports whether it did so. When the flag is set to
+// "text" (the default) it writes nothing and returns false so the caller can
+// fall back to its normal human-readable rendering.
+func maybeWriteStructuredOutput(w io.Writer, v interface{}) (bool, error) {
+	switch *outputFormatFlag {
+	case outputFormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return true, enc.Encode(v)
+
+	case outputFormatYAML:
+		b, err := yaml.Marshal(v)
+		if err != nil {
+			return true, err
+		}
+		_, err = w.Write(b)
+		return true, err
+
+	default:
+		return false, nil
+	}
+}
@@ -0,0 +1,112 @@
+package manifest
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Codec encodes and decodes the contents of a manifest block.
+type Codec interface {
+	// Encode returns a writer that compresses data written to it using this codec.
+	Encode(w io.Writer) io.WriteCloser
+
+	// Decode returns a reader that decompresses data read from it using this codec.
+	Decode(r io.Reader) (io.ReadCloser, error)
+
+	// Name returns the codec's short name, as used in CLI flags and diagnostics.
+	Name() string
+}
+
+// codecPrefix bytes are written as the first byte of newly-written manifest
+// blocks to identify the codec used to compress them. They are chosen to
+// avoid colliding with the legacy detection bytes: '{' (uncompressed JSON)
+// and 0x1f (gzip magic).
+const (
+	codecPrefixNone byte = 0x01
+	codecPrefixGzip byte = 0x02
+	codecPrefixZstd byte = 0x03
+)
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+type noneCodec struct{}
+
+func (noneCodec) Encode(w io.Writer) io.WriteCloser       { return nopWriteCloser{w} }
+func (noneCodec) Decode(r io.Reader) (io.ReadCloser, error) { return ioutil.NopCloser(r), nil }
+func (noneCodec) Name() string                            { return "none" }
+
+type gzipCodec struct{}
+
+func (gzipCodec) Encode(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) }
+
+func (gzipCodec) Decode(r io.Reader) (io.ReadCloser, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return gz, nil
+}
+
+func (gzipCodec) Name() string { return "gzip" }
+
+type zstdCodec struct{}
+
+func (zstdCodec) Encode(w io.Writer) io.WriteCloser {
+	enc, err := zstd.NewWriter(w)
+	if err != nil {
+		// zstd.NewWriter only fails on invalid options, which we never pass.
+		panic(fmt.Sprintf("unable to create zstd encoder: %v", err))
+	}
+	return enc
+}
+
+func (zstdCodec) Decode(r io.Reader) (io.ReadCloser, error) {
+	dec, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return dec.IOReadCloser(), nil
+}
+
+func (zstdCodec) Name() string { return "zstd" }
+
+var codecsByName = map[string]Codec{
+	"none": noneCodec{},
+	"gzip": gzipCodec{},
+	"zstd": zstdCodec{},
+}
+
+var codecsByPrefix = map[byte]Codec{
+	codecPrefixNone: noneCodec{},
+	codecPrefixGzip: gzipCodec{},
+	codecPrefixZstd: zstdCodec{},
+}
+
+var codecPrefixesByName = map[string]byte{
+	"none": codecPrefixNone,
+	"gzip": codecPrefixGzip,
+	"zstd": codecPrefixZstd,
+}
+
+// DefaultCodec is used when LoadOptions does not specify one.
+func DefaultCodec() Codec {
+	return codecsByName["gzip"]
+}
+
+// CodecByName returns the Codec registered under the given name, such as
+// "none", "gzip" or "zstd".
+func CodecByName(name string) (Codec, error) {
+	c, ok := codecsByName[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown manifest codec %q", name)
+	}
+	return c, nil
+}
@@ -0,0 +1,70 @@
+package manifest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func benchmarkManifestEntries(n int) []*manifestEntry {
+	entries := make([]*manifestEntry, n)
+	for i := 0; i < n; i++ {
+		entries[i] = &manifestEntry{
+			ID:      fmt.Sprintf("%032x", i),
+			ModTime: time.Now().UTC(),
+			Labels:  map[string]string{"type": "snapshot", "path": fmt.Sprintf("/data/dir-%d", i%100)},
+			Content: []byte(fmt.Sprintf(`{"size":%d,"files":%d}`, i*1024, i)),
+		}
+	}
+	return entries
+}
+
+// benchmarkCodec measures encode and decode throughput for codec over a
+// manifest with ~10k entries, representative of a large snapshot fleet.
+func benchmarkCodec(b *testing.B, codec Codec) {
+	man := manifest{Entries: benchmarkManifestEntries(10000)}
+
+	var encoded bytes.Buffer
+	w := codec.Encode(&encoded)
+	if err := json.NewEncoder(w).Encode(man); err != nil {
+		b.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("encode", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var buf bytes.Buffer
+			w := codec.Encode(&buf)
+			if err := json.NewEncoder(w).Encode(man); err != nil {
+				b.Fatal(err)
+			}
+			if err := w.Close(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("decode", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			r, err := codec.Decode(bytes.NewReader(encoded.Bytes()))
+			if err != nil {
+				b.Fatal(err)
+			}
+			var out manifest
+			if err := json.NewDecoder(r).Decode(&out); err != nil {
+				b.Fatal(err)
+			}
+			r.Close() //nolint:errcheck
+		}
+	})
+}
+
+func BenchmarkCodecNone(b *testing.B) { benchmarkCodec(b, noneCodec{}) }
+func BenchmarkCodecGzip(b *testing.B) { benchmarkCodec(b, gzipCodec{}) }
+func BenchmarkCodecZstd(b *testing.B) { benchmarkCodec(b, zstdCodec{}) }
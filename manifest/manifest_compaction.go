@@ -0,0 +1,243 @@
+package manifest
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/kopia/kopia/block"
+	"github.com/rs/zerolog/log"
+)
+
+// CompactOptions controls how compaction groups and rewrites manifest blocks.
+type CompactOptions struct {
+	// MaxBlockSize is the target size, in bytes, of a block produced by
+	// merging smaller ones. A bucket of blocks whose combined size would
+	// exceed this is not merged into a single block.
+	MaxBlockSize int64
+
+	// MaxBlocksPerRun caps the number of source blocks considered for
+	// rewriting in a single compaction pass, bounding how much work one
+	// call to Compact (or one auto-compaction) does.
+	MaxBlocksPerRun int
+
+	// MinBlocksToMerge is the minimum number of blocks a bucket must contain
+	// before it is worth rewriting; buckets smaller than this are left alone.
+	MinBlocksToMerge int
+}
+
+const (
+	defaultMaxBlockSize     = 4 << 20 // 4 MiB
+	defaultMaxBlocksPerRun  = 64
+	defaultMinBlocksToMerge = 2
+
+	// autoCompactionTotalSizeBytes triggers automatic compaction once the
+	// total size of manifest blocks crosses this threshold, independent of
+	// autoCompactionBlockCount.
+	autoCompactionTotalSizeBytes = 32 << 20 // 32 MiB
+)
+
+// DefaultCompactOptions are used whenever LoadOptions.Compact is left
+// zero-valued.
+func DefaultCompactOptions() CompactOptions {
+	return CompactOptions{
+		MaxBlockSize:     defaultMaxBlockSize,
+		MaxBlocksPerRun:  defaultMaxBlocksPerRun,
+		MinBlocksToMerge: defaultMinBlocksToMerge,
+	}
+}
+
+// compactOptionsLocked returns m.opts.Compact with any zero-valued fields
+// filled in from DefaultCompactOptions. Callers must hold m.mu.
+func (m *Manager) compactOptionsLocked() CompactOptions {
+	o := m.opts.Compact
+	if o.MaxBlockSize <= 0 {
+		o.MaxBlockSize = defaultMaxBlockSize
+	}
+	if o.MaxBlocksPerRun <= 0 {
+		o.MaxBlocksPerRun = defaultMaxBlocksPerRun
+	}
+	if o.MinBlocksToMerge <= 0 {
+		o.MinBlocksToMerge = defaultMinBlocksToMerge
+	}
+	return o
+}
+
+// shouldAutoCompactLocked reports whether enough manifest blocks have
+// accumulated, by count or by total size, to warrant automatic compaction.
+// Callers must hold m.mu.
+func (m *Manager) shouldAutoCompactLocked() bool {
+	if len(m.blockIDs) > autoCompactionBlockCount {
+		return true
+	}
+
+	return m.totalBlockSizeLocked() > autoCompactionTotalSizeBytes
+}
+
+func (m *Manager) totalBlockSizeLocked() int64 {
+	var total int64
+	for _, b := range m.blockIDs {
+		total += m.blockSizes[b]
+	}
+
+	return total
+}
+
+// compactionBucket groups together manifest blocks to be physically merged
+// into one new block because their combined size is below MaxBlockSize.
+type compactionBucket struct {
+	blockIDs []block.ContentID
+	size     int64
+}
+
+func (m *Manager) compactLocked(ctx context.Context) error {
+	log.Printf("compactLocked: pendingEntries=%v blockIDs=%v", len(m.pendingEntries), len(m.blockIDs))
+
+	blockID, err := m.flushPendingEntriesLocked(ctx)
+	if err != nil {
+		return err
+	}
+	if blockID != "" {
+		m.blockIDs = append(m.blockIDs, blockID)
+	}
+
+	if len(m.blockIDs) <= 1 {
+		m.updateGaugesLocked()
+		return nil
+	}
+
+	opts := m.compactOptionsLocked()
+
+	for _, bucket := range m.buildCompactionBucketsLocked(opts) {
+		if err := m.compactBucketLocked(ctx, bucket); err != nil {
+			return fmt.Errorf("unable to compact manifest blocks: %v", err)
+		}
+
+		if m.metrics != nil {
+			m.metrics.compactionsRun.Inc()
+		}
+	}
+
+	m.updateGaugesLocked()
+
+	return nil
+}
+
+// buildCompactionBucketsLocked groups m.blockIDs, smallest first, into
+// size-bounded buckets and returns only the ones worth merging (those with
+// at least MinBlocksToMerge blocks), considering at most MaxBlocksPerRun
+// blocks in total. Callers must hold m.mu.
+func (m *Manager) buildCompactionBucketsLocked(opts CompactOptions) []compactionBucket {
+	// smallest blocks are the best candidates for merging.
+	ordered := append([]block.ContentID(nil), m.blockIDs...)
+	sort.Slice(ordered, func(i, j int) bool {
+		return m.blockSizes[ordered[i]] < m.blockSizes[ordered[j]]
+	})
+
+	if len(ordered) > opts.MaxBlocksPerRun {
+		ordered = ordered[:opts.MaxBlocksPerRun]
+	}
+
+	var buckets []compactionBucket
+	var current compactionBucket
+
+	flush := func() {
+		if len(current.blockIDs) >= opts.MinBlocksToMerge {
+			buckets = append(buckets, current)
+		}
+		current = compactionBucket{}
+	}
+
+	for _, b := range ordered {
+		size := m.blockSizes[b]
+		if len(current.blockIDs) > 0 && current.size+size > opts.MaxBlockSize {
+			flush()
+		}
+
+		current.blockIDs = append(current.blockIDs, b)
+		current.size += size
+	}
+	flush()
+
+	return buckets
+}
+
+// compactBucketLocked physically merges the blocks in bucket into a single
+// new block, flushes the block manager, and only then deletes the source
+// blocks. If the process is interrupted between the write and the deletes,
+// the source blocks remain alongside the new one; the next load simply sees
+// duplicate copies of their entries and deduplicates them via mergeEntry.
+//
+// The merged block is reconciled against the live, deduplicated m.entries
+// rather than carrying forward the raw per-block entry lists verbatim: an
+// entry is only carried forward if its copy in this bucket is the current
+// winner for its ID (live == e, by pointer, since mergeEntry never mutates
+// an entry in place). A bucket entry that lost to a copy living in some
+// other block is dropped silently rather than duplicated again — that other
+// block already owns the live copy, so re-writing it here would leave two
+// live-looking copies on disk and defeat reclamation on the next pass.
+// Superseded versions and delete tombstones are dropped the same way, so
+// that repeatedly compacting doesn't reshuffle dead data into new blocks
+// forever. Callers must hold m.mu.
+func (m *Manager) compactBucketLocked(ctx context.Context, bucket compactionBucket) error {
+	seen := map[string]bool{}
+	var merged []*manifestEntry
+
+	for _, b := range bucket.blockIDs {
+		for _, e := range m.blockEntries[b] {
+			if seen[e.ID] {
+				continue
+			}
+
+			if live, ok := m.entries[e.ID]; ok && live == e {
+				seen[e.ID] = true
+				merged = append(merged, live)
+			}
+		}
+	}
+
+	newBlockID, err := m.writeManifestBlockLocked(ctx, merged)
+	if err != nil {
+		return err
+	}
+
+	if err := m.b.Flush(ctx); err != nil {
+		return fmt.Errorf("unable to flush after writing merged block: %v", err)
+	}
+
+	for _, b := range bucket.blockIDs {
+		if b == newBlockID {
+			// writeManifestBlockLocked happened to reuse an existing ID; nothing to delete.
+			continue
+		}
+
+		if err := m.b.DeleteBlock(b); err != nil {
+			return fmt.Errorf("unable to delete block %q: %v", b, err)
+		}
+
+		delete(m.blockSizes, b)
+		delete(m.blockEntries, b)
+	}
+
+	m.blockIDs = replaceBlockIDs(m.blockIDs, bucket.blockIDs, newBlockID)
+
+	return nil
+}
+
+// replaceBlockIDs returns a copy of ids with every member of removed dropped
+// and replacement appended once.
+func replaceBlockIDs(ids, removed []block.ContentID, replacement block.ContentID) []block.ContentID {
+	removedSet := make(map[block.ContentID]bool, len(removed))
+	for _, b := range removed {
+		removedSet[b] = true
+	}
+
+	var out []block.ContentID
+	for _, b := range ids {
+		if !removedSet[b] {
+			out = append(out, b)
+		}
+	}
+
+	return append(out, replacement)
+}
@@ -2,7 +2,6 @@ package manifest
 
 import (
 	"bytes"
-	"compress/gzip"
 	"context"
 	"crypto/rand"
 	"encoding/hex"
@@ -22,14 +21,68 @@ var ErrNotFound = errors.New("not found")
 
 const manifestBlockPrefix = "m"
 const autoCompactionBlockCount = 16
+const gzipMagic0 = 0x1f // first byte of the gzip magic number
+
+// LoadOptions controls how manifest blocks are loaded when a Manager is opened.
+type LoadOptions struct {
+	// IgnoreLoadErrors causes per-block read/parse errors encountered while
+	// loading manifest blocks to be skipped and recorded in a LoadReport
+	// instead of failing the entire load.
+	IgnoreLoadErrors bool
+
+	// Codec is used to compress newly-written manifest blocks. Defaults to
+	// DefaultCodec() when nil. Existing blocks are always read using the
+	// codec recorded in their own 1-byte prefix (or the legacy detection
+	// rules for blocks written before codecs were introduced).
+	Codec Codec
+
+	// Compact controls how auto- and manually-triggered compaction groups
+	// and rewrites manifest blocks. Defaults to DefaultCompactOptions() when
+	// zero-valued.
+	Compact CompactOptions
+}
+
+// BlockLoadError describes a single manifest block that could not be loaded.
+type BlockLoadError struct {
+	BlockID   block.ContentID
+	Size      int
+	Err       error
+	GzipError bool // true if the block looked like gzip but failed to decompress
+	JSONError bool // true if the block (or its decompressed contents) failed to parse as JSON
+}
+
+// LoadReport summarizes the manifest blocks that were skipped while loading
+// because of read or parse errors.
+type LoadReport struct {
+	Errors []BlockLoadError
+}
+
+// IsEmpty returns true if the report contains no errors.
+func (r *LoadReport) IsEmpty() bool {
+	return r == nil || len(r.Errors) == 0
+}
 
 // Manager organizes JSON manifests of various kinds, including snapshot manifests
 type Manager struct {
 	mu             sync.Mutex
 	b              *block.Manager
+	opts           LoadOptions
 	entries        map[string]*manifestEntry
 	blockIDs       []block.ContentID
+	blockSizes     map[block.ContentID]int64
+	blockEntries   map[block.ContentID][]*manifestEntry
 	pendingEntries []*manifestEntry
+	lastLoadReport *LoadReport
+	metrics        *Metrics
+}
+
+// LastLoadReport returns the LoadReport produced by the most recent load of
+// manifest blocks, or nil if the load encountered no errors.
+func (m *Manager) LastLoadReport() *LoadReport {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.lastLoadReport
 }
 
 // Put serializes the provided payload to JSON and persists it. Returns unique handle that represents the object.
@@ -158,10 +211,12 @@ func (m *Manager) Flush(ctx context.Context) error {
 	}
 
 	if blockID == "" {
+		m.updateGaugesLocked()
 		return nil
 	}
 
 	m.blockIDs = append(m.blockIDs, blockID)
+	m.updateGaugesLocked()
 	return nil
 }
 
@@ -170,20 +225,36 @@ func (m *Manager) flushPendingEntriesLocked(ctx context.Context) (block.ContentI
 		return "", nil
 	}
 
+	blockID, err := m.writeManifestBlockLocked(ctx, m.pendingEntries)
+	if err != nil {
+		return "", err
+	}
+
+	m.pendingEntries = nil
+	return blockID, nil
+}
+
+// writeManifestBlockLocked encodes entries into a new manifest block, writes
+// it to the underlying block manager and records its size and contents so
+// that subsequent compaction passes can consider it. Callers must hold m.mu.
+func (m *Manager) writeManifestBlockLocked(ctx context.Context, entries []*manifestEntry) (block.ContentID, error) {
 	man := manifest{
-		Entries: m.pendingEntries,
+		Entries: entries,
+	}
+
+	codec := m.opts.Codec
+	if codec == nil {
+		codec = DefaultCodec()
 	}
 
 	var buf bytes.Buffer
+	buf.WriteByte(codecPrefixesByName[codec.Name()])
 
-	gz := gzip.NewWriter(&buf)
-	if err := json.NewEncoder(gz).Encode(man); err != nil {
+	w := codec.Encode(&buf)
+	if err := json.NewEncoder(w).Encode(man); err != nil {
 		return "", fmt.Errorf("unable to marshal: %v", err)
 	}
-	if err := gz.Flush(); err != nil {
-		return "", fmt.Errorf("unable to flush: %v", err)
-	}
-	if err := gz.Close(); err != nil {
+	if err := w.Close(); err != nil {
 		return "", fmt.Errorf("unable to close: %v", err)
 	}
 
@@ -192,7 +263,9 @@ func (m *Manager) flushPendingEntriesLocked(ctx context.Context) (block.ContentI
 		return "", err
 	}
 
-	m.pendingEntries = nil
+	m.blockSizes[blockID] = int64(buf.Len())
+	m.blockEntries[blockID] = entries
+
 	return blockID, nil
 }
 
@@ -211,6 +284,8 @@ func (m *Manager) Delete(id string) {
 }
 
 func (m *Manager) load(ctx context.Context) error {
+	t0 := time.Now()
+
 	if err := m.Flush(ctx); err != nil {
 		return err
 	}
@@ -218,6 +293,14 @@ func (m *Manager) load(ctx context.Context) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	// Registered after the Unlock defer above so it runs first (LIFO),
+	// while m.mu is still held, to avoid racing with RegisterMetrics.
+	defer func() {
+		if m.metrics != nil {
+			m.metrics.syncDuration.Observe(time.Since(t0).Seconds())
+		}
+	}()
+
 	m.entries = map[string]*manifestEntry{}
 
 	log.Debug().Msg("listing manifest blocks")
@@ -228,11 +311,20 @@ func (m *Manager) load(ctx context.Context) error {
 
 	log.Printf("found %v manifest blocks", len(blocks))
 
-	if err := m.loadManifestBlocks(ctx, blocks); err != nil {
+	report, err := m.loadManifestBlocks(ctx, blocks)
+	if err != nil {
 		return fmt.Errorf("unable to load manifest blocks: %v", err)
 	}
 
-	if len(blocks) > autoCompactionBlockCount {
+	m.lastLoadReport = report
+	m.updateGaugesLocked()
+
+	if !report.IsEmpty() {
+		log.Warn().Int("errors", len(report.Errors)).Msg("manifest load encountered bad blocks, skipping auto-compaction")
+		return nil
+	}
+
+	if m.shouldAutoCompactLocked() {
 		log.Debug().Int("blocks", len(blocks)).Msg("performing automatic compaction")
 		if err := m.compactLocked(ctx); err != nil {
 			return fmt.Errorf("unable to compact manifest blocks: %v", err)
@@ -246,22 +338,41 @@ func (m *Manager) load(ctx context.Context) error {
 	return nil
 }
 
-func (m *Manager) loadManifestBlocks(ctx context.Context, blocks []block.Info) error {
+// updateGaugesLocked refreshes the pending-entries and block-count gauges. Callers must hold m.mu.
+func (m *Manager) updateGaugesLocked() {
+	if m.metrics == nil {
+		return
+	}
+
+	m.metrics.pendingEntries.Set(float64(len(m.pendingEntries)))
+	m.metrics.blockIDs.Set(float64(len(m.blockIDs)))
+}
+
+// loadedManifestBlock carries a successfully-decoded block alongside the
+// block ID it came from, so it can be tracked for future compaction.
+type loadedManifestBlock struct {
+	blockID block.ContentID
+	man     manifest
+}
+
+func (m *Manager) loadManifestBlocks(ctx context.Context, blocks []block.Info) (*LoadReport, error) {
 	t0 := time.Now()
 
 	log.Debug().Dur("duration_ms", time.Since(t0)).Msgf("finished loading manifest blocks.")
 
 	for _, b := range blocks {
 		m.blockIDs = append(m.blockIDs, b.BlockID)
+		m.blockSizes[b.BlockID] = int64(b.Length)
 	}
 
-	manifests, err := m.loadBlocksInParallel(ctx, blocks)
+	loaded, report, err := m.loadBlocksInParallel(ctx, blocks)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	for _, man := range manifests {
-		for _, e := range man.Entries {
+	for _, lb := range loaded {
+		m.blockEntries[lb.blockID] = lb.man.Entries
+		for _, e := range lb.man.Entries {
 			m.mergeEntry(e)
 		}
 	}
@@ -270,15 +381,18 @@ func (m *Manager) loadManifestBlocks(ctx context.Context, blocks []block.Info) e
 	for k, e := range m.entries {
 		if e.Deleted {
 			delete(m.entries, k)
+			if m.metrics != nil {
+				m.metrics.entriesDeleted.Inc()
+			}
 		}
 	}
 
-	return nil
+	return report, nil
 }
 
-func (m *Manager) loadBlocksInParallel(ctx context.Context, blocks []block.Info) ([]manifest, error) {
-	errors := make(chan error, len(blocks))
-	manifests := make(chan manifest, len(blocks))
+func (m *Manager) loadBlocksInParallel(ctx context.Context, blocks []block.Info) ([]loadedManifestBlock, *LoadReport, error) {
+	loadErrors := make(chan BlockLoadError, len(blocks))
+	loadedBlocks := make(chan loadedManifestBlock, len(blocks))
 	blockIDs := make(chan block.ContentID, len(blocks))
 	var wg sync.WaitGroup
 
@@ -289,12 +403,24 @@ func (m *Manager) loadBlocksInParallel(ctx context.Context, blocks []block.Info)
 
 			for blk := range blockIDs {
 				t1 := time.Now()
-				man, err := m.loadManifestBlock(ctx, blk)
-				log.Debug().Dur("duration", time.Since(t1)).Str("blk", string(blk)).Int("worker", workerID).Msg("manifest block loaded")
-				if err != nil {
-					errors <- err
+				man, blkErr := m.loadManifestBlock(ctx, blk)
+				duration := time.Since(t1)
+				log.Debug().Dur("duration", duration).Str("blk", string(blk)).Int("worker", workerID).Msg("manifest block loaded")
+
+				if m.metrics != nil {
+					m.metrics.blockLoadDuration.Observe(duration.Seconds())
+				}
+
+				if blkErr.Err != nil {
+					if m.metrics != nil {
+						m.metrics.loadFailures.Inc()
+					}
+					loadErrors <- blkErr
 				} else {
-					manifests <- man
+					if m.metrics != nil {
+						m.metrics.blocksLoaded.Inc()
+					}
+					loadedBlocks <- loadedManifestBlock{blockID: blk, man: man}
 				}
 			}
 		}(i)
@@ -308,91 +434,104 @@ func (m *Manager) loadBlocksInParallel(ctx context.Context, blocks []block.Info)
 
 	// wait for workers to complete
 	wg.Wait()
-	close(errors)
-	close(manifests)
+	close(loadErrors)
+	close(loadedBlocks)
 
-	// if there was any error, forward it
-	if err := <-errors; err != nil {
-		return nil, err
+	var report *LoadReport
+	for le := range loadErrors {
+		if !m.opts.IgnoreLoadErrors {
+			return nil, nil, le.Err
+		}
+
+		log.Warn().Str("blk", string(le.BlockID)).Int("size", le.Size).Err(le.Err).Msg("skipping unreadable manifest block")
+
+		if report == nil {
+			report = &LoadReport{}
+		}
+		report.Errors = append(report.Errors, le)
 	}
 
-	var man []manifest
-	for m := range manifests {
-		man = append(man, m)
+	var loaded []loadedManifestBlock
+	for lb := range loadedBlocks {
+		loaded = append(loaded, lb)
 	}
 
-	return man, nil
+	return loaded, report, nil
 }
 
-func (m *Manager) loadManifestBlock(ctx context.Context, blockID block.ContentID) (manifest, error) {
+func (m *Manager) loadManifestBlock(ctx context.Context, blockID block.ContentID) (manifest, BlockLoadError) {
 	man := manifest{}
 	blk, err := m.b.GetBlock(ctx, blockID)
 	if err != nil {
-		return man, fmt.Errorf("unable to read block %q: %v", blockID, err)
+		return man, BlockLoadError{BlockID: blockID, Err: fmt.Errorf("unable to read block %q: %v", blockID, err)}
+	}
+
+	if len(blk) < 2 {
+		return man, BlockLoadError{BlockID: blockID, Size: len(blk), Err: fmt.Errorf("block %q is too short", blockID)}
 	}
 
-	if len(blk) > 2 && blk[0] == '{' {
+	switch {
+	case blk[0] == '{':
+		// legacy uncompressed JSON block.
 		if err := json.Unmarshal(blk, &man); err != nil {
-			return man, fmt.Errorf("unable to parse block %q: %v", blockID, err)
+			return man, BlockLoadError{BlockID: blockID, Size: len(blk), Err: fmt.Errorf("unable to parse block %q: %v", blockID, err), JSONError: true}
 		}
-	} else {
-		gz, err := gzip.NewReader(bytes.NewReader(blk))
+
+	case blk[0] == gzipMagic0:
+		// legacy gzip block written before codec prefixes were introduced.
+		gz, err := gzipCodec{}.Decode(bytes.NewReader(blk))
 		if err != nil {
-			return man, fmt.Errorf("unable to unpack block %q: %v", blockID, err)
+			return man, BlockLoadError{BlockID: blockID, Size: len(blk), Err: fmt.Errorf("unable to unpack block %q: %v", blockID, err), GzipError: true}
 		}
+		defer gz.Close() //nolint:errcheck
 
 		if err := json.NewDecoder(gz).Decode(&man); err != nil {
-			return man, fmt.Errorf("unable to parse block %q: %v", blockID, err)
+			return man, BlockLoadError{BlockID: blockID, Size: len(blk), Err: fmt.Errorf("unable to parse block %q: %v", blockID, err), JSONError: true}
+		}
+
+	default:
+		codec, ok := codecsByPrefix[blk[0]]
+		if !ok {
+			return man, BlockLoadError{BlockID: blockID, Size: len(blk), Err: fmt.Errorf("unrecognized codec prefix in block %q", blockID)}
+		}
+
+		r, err := codec.Decode(bytes.NewReader(blk[1:]))
+		if err != nil {
+			return man, BlockLoadError{BlockID: blockID, Size: len(blk), Err: fmt.Errorf("unable to unpack block %q: %v", blockID, err), GzipError: codec.Name() == "gzip"}
+		}
+		defer r.Close() //nolint:errcheck
+
+		if err := json.NewDecoder(r).Decode(&man); err != nil {
+			return man, BlockLoadError{BlockID: blockID, Size: len(blk), Err: fmt.Errorf("unable to parse block %q: %v", blockID, err), JSONError: true}
 		}
 	}
 
-	return man, nil
+	return man, BlockLoadError{Size: len(blk)}
 }
 
-// Compact performs compaction of manifest blocks.
+// Compact performs compaction of manifest blocks. It compacts nothing and
+// returns an error if the most recent load encountered any blocks it could
+// not read: compaction deletes
+// its source blocks once they've been merged, and a block skipped via
+// LoadOptions.IgnoreLoadErrors has no entries recorded for it in
+// m.blockEntries, so compacting alongside it would merge it in as empty data
+// and then delete the only copy of whatever it actually contained.
 func (m *Manager) Compact(ctx context.Context) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	return m.compactLocked(ctx)
-}
-
-func (m *Manager) compactLocked(ctx context.Context) error {
-	log.Printf("compactLocked: pendingEntries=%v blockIDs=%v", len(m.pendingEntries), len(m.blockIDs))
-
-	if len(m.blockIDs) == 1 && len(m.pendingEntries) == 0 {
-		return nil
-	}
-
-	for _, e := range m.entries {
-		m.pendingEntries = append(m.pendingEntries, e)
+	if m.lastLoadReport != nil && !m.lastLoadReport.IsEmpty() {
+		return fmt.Errorf("refusing to compact: last load reported %v unreadable block(s)", len(m.lastLoadReport.Errors))
 	}
 
-	blockID, err := m.flushPendingEntriesLocked(ctx)
-	if err != nil {
-		return err
-	}
-
-	// add the newly-created block to the list, could be duplicate
-	m.blockIDs = append(m.blockIDs, blockID)
-
-	for _, b := range m.blockIDs {
-		if b == blockID {
-			// do not delete block that was just written.
-			continue
-		}
-
-		if err := m.b.DeleteBlock(b); err != nil {
-			return fmt.Errorf("unable to delete block %q: %v", b, err)
-		}
-	}
-
-	// all previous blocks were deleted, now we have a new block
-	m.blockIDs = []block.ContentID{blockID}
-	return nil
+	return m.compactLocked(ctx)
 }
 
 func (m *Manager) mergeEntry(e *manifestEntry) {
+	if m.metrics != nil {
+		m.metrics.entriesMerged.Inc()
+	}
+
 	prev := m.entries[e.ID]
 	if prev == nil {
 		m.entries[e.ID] = e
@@ -413,10 +552,13 @@ func copyLabels(m map[string]string) map[string]string {
 }
 
 // NewManager returns new manifest manager for the provided block manager.
-func NewManager(ctx context.Context, b *block.Manager) (*Manager, error) {
+func NewManager(ctx context.Context, b *block.Manager, opts LoadOptions) (*Manager, error) {
 	m := &Manager{
-		b:       b,
-		entries: map[string]*manifestEntry{},
+		b:            b,
+		opts:         opts,
+		entries:      map[string]*manifestEntry{},
+		blockSizes:   map[block.ContentID]int64{},
+		blockEntries: map[block.ContentID][]*manifestEntry{},
 	}
 
 	if err := m.load(ctx); err != nil {